@@ -0,0 +1,71 @@
+package main
+
+import (
+  "os"
+  "path/filepath"
+  "sync"
+  "testing"
+  "time"
+)
+
+func TestMin(t *testing.T) {
+  if got := Min(3, 1, 2); got != 1 {
+    t.Errorf("Min(3, 1, 2) = %d, want 1", got)
+  }
+  if got := Min(5, 5, 5); got != 5 {
+    t.Errorf("Min(5, 5, 5) = %d, want 5", got)
+  }
+}
+
+func TestIsTarball(t *testing.T) {
+  cases := map[string]bool{
+    "server-descriptors-2015-11":        false,
+    "server-descriptors-2015-11.tar":    true,
+    "server-descriptors-2015-11.tar.gz": true,
+    "server-descriptors-2015-11.tar.xz": true,
+  }
+  for path, want := range cases {
+    if got := isTarball(path); got != want {
+      t.Errorf("isTarball(%q) = %v, want %v", path, got, want)
+    }
+  }
+}
+
+func TestWalkArchive(t *testing.T) {
+  dir := t.TempDir()
+  for _, name := range []string{"a", "b", "c"} {
+    if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  var mu sync.Mutex
+  var seen []string
+  err := walkArchive(dir, 2, func(path string) error {
+    mu.Lock()
+    defer mu.Unlock()
+    seen = append(seen, filepath.Base(path))
+    return nil
+  })
+  if err != nil {
+    t.Fatalf("walkArchive: %v", err)
+  }
+  if len(seen) != 3 {
+    t.Errorf("visited %d files, want 3: %v", len(seen), seen)
+  }
+}
+
+// silentReporter and terminalReporter must satisfy ProgressReporter; this is
+// a compile-time check, and the no-op calls below confirm silentReporter
+// doesn't panic now that its methods carry an elapsed duration.
+var (
+  _ ProgressReporter = silentReporter{}
+  _ ProgressReporter = (*terminalReporter)(nil)
+)
+
+func TestSilentReporterIsANoop(t *testing.T) {
+  var r silentReporter
+  r.OnFile("some/path", time.Millisecond)
+  r.OnBatch(1, 10)
+  r.OnDone(Stats{ProcessedCount: 1})
+}
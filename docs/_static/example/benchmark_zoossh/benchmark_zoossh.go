@@ -0,0 +1,656 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/ulikunitz/xz"
+	_ "modernc.org/sqlite"
+
+	"git.torproject.org/user/phw/zoossh.git"
+)
+
+var (
+	metricsAddr = flag.String("metrics-addr", "", "address to serve Prometheus metrics on, e.g. :9090")
+	sqlitePath  = flag.String("sqlite", "", "path to a SQLite database to write parsed records into")
+	jsonLog     = flag.Bool("json-log", false, "emit structured (slog) progress lines instead of plain text")
+)
+
+var (
+	filesParsedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "zoossh_files_parsed_total",
+		Help: "Number of archive files successfully parsed, by kind.",
+	}, []string{"kind"})
+
+	parseErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "zoossh_parse_errors_total",
+		Help: "Number of archive files that failed to parse, by kind and reason.",
+	}, []string{"kind", "reason"})
+
+	parseDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "zoossh_parse_duration_seconds",
+		Help:    "Time spent parsing a single archive file, by kind.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"kind"})
+
+	routerStatusesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "zoossh_router_statuses_total",
+		Help: "Number of router status entries seen across all consensuses.",
+	})
+
+	exitRelaysTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "zoossh_exit_relays_total",
+		Help: "Number of router status entries seen with the Exit flag set.",
+	})
+
+	bandwidthBytesAvg = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "zoossh_bandwidth_bytes_avg",
+		Help: "Average advertised bandwidth across the last processed descriptor archive.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		filesParsedTotal,
+		parseErrorsTotal,
+		parseDurationSeconds,
+		routerStatusesTotal,
+		exitRelaysTotal,
+		bandwidthBytesAvg,
+	)
+}
+
+// Kind identifies which half of a benchmark run a ProgressReporter callback
+// belongs to.
+type Kind int
+
+const (
+	KindConsensus Kind = iota
+	KindDescriptor
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindConsensus:
+		return "consensus"
+	case KindDescriptor:
+		return "descriptor"
+	default:
+		return "unknown"
+	}
+}
+
+// ProgressReporter is notified as a walk makes progress, so callers can swap
+// in their own UI (or none at all, via silentReporter) without walkConsensuses
+// and walkDescriptors knowing about terminals.
+type ProgressReporter interface {
+	OnFile(path string, kind Kind, elapsed time.Duration)
+	OnBatch(processed, total int64)
+	OnDone(kind Kind, stats Stats)
+}
+
+// Logger is the Printf-style sink terminalReporter writes through. Both
+// *log.Logger and slogPrintf (wrapping *slog.Logger) satisfy it.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+// slogPrintf adapts an *slog.Logger to the Printf-style Logger interface, so
+// the benchmark can log through either a standard *log.Logger (which already
+// has Printf) or a structured *slog.Logger.
+type slogPrintf struct {
+	l *slog.Logger
+}
+
+func (s slogPrintf) Printf(format string, args ...interface{}) {
+	s.l.Info(fmt.Sprintf(format, args...))
+}
+
+// terminalReporter implements ProgressReporter by printing a single,
+// 500ms-throttled status line instead of a dot per 100 files, so long runs
+// over a full month of archives give a sense of progress (files/sec)
+// without scrolling the terminal. An ETA is added to the line only when
+// OnBatch is given a non-zero total; walkConsensuses and walkDescriptors
+// always pass 0, so their lines carry no ETA.
+type terminalReporter struct {
+	logger Logger
+	start  time.Time
+
+	mu        sync.Mutex
+	lastPrint time.Time
+}
+
+func newTerminalReporter(logger Logger) *terminalReporter {
+	return &terminalReporter{logger: logger, start: time.Now()}
+}
+
+func (r *terminalReporter) OnFile(path string, kind Kind, elapsed time.Duration) {}
+
+func (r *terminalReporter) OnBatch(processed, total int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(r.lastPrint) < 500*time.Millisecond {
+		return
+	}
+	r.lastPrint = now
+
+	elapsed := now.Sub(r.start)
+	rate := float64(processed) / elapsed.Seconds()
+
+	if total > 0 && rate > 0 {
+		eta := time.Duration(float64(total-processed)/rate) * time.Second
+		r.logger.Printf("%d/%d files (%.1f files/sec, ETA %s)", processed, total, rate, eta)
+	} else {
+		r.logger.Printf("%d files (%.1f files/sec)", processed, rate)
+	}
+}
+
+func (r *terminalReporter) OnDone(kind Kind, stats Stats) {
+	r.logger.Printf("%s done: %+v", kind, stats)
+}
+
+// silentReporter discards all progress notifications, for callers (tests,
+// CI) that don't want status lines interleaved with their own output.
+type silentReporter struct{}
+
+func (silentReporter) OnFile(path string, kind Kind, elapsed time.Duration) {}
+func (silentReporter) OnBatch(processed, total int64)                       {}
+func (silentReporter) OnDone(kind Kind, stats Stats)                        {}
+
+// Sink persists parsed records as a walk processes them, keyed so the
+// resulting database can be queried by relay and by time: router_status by
+// (valid_after, fingerprint) and router_descriptor by (published,
+// fingerprint). WriteStatus and WriteDescriptor are called once per record;
+// Flush is called once per archive file, so a sink can batch an entire
+// file's writes into a single transaction.
+type Sink interface {
+	WriteStatus(validAfter time.Time, status *zoossh.RouterStatus) error
+	WriteDescriptor(desc *zoossh.RouterDescriptor) error
+	Flush() error
+	Discard() error
+}
+
+// sqliteSink writes parsed records into a SQLite database via the real
+// modernc.org/sqlite driver, committing one transaction per archive file.
+type sqliteSink struct {
+	db           *sql.DB
+	tx           *sql.Tx
+	insertStatus *sql.Stmt
+	insertDesc   *sql.Stmt
+}
+
+func openSQLiteSink(path string) (*sqliteSink, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS router_status (
+			valid_after INTEGER NOT NULL,
+			fingerprint TEXT NOT NULL,
+			exit_flag   BOOLEAN NOT NULL,
+			PRIMARY KEY (valid_after, fingerprint)
+		)`,
+		`CREATE TABLE IF NOT EXISTS router_descriptor (
+			published       INTEGER NOT NULL,
+			fingerprint     TEXT NOT NULL,
+			bandwidth_avg   INTEGER NOT NULL,
+			bandwidth_burst INTEGER NOT NULL,
+			bandwidth_obs   INTEGER NOT NULL,
+			PRIMARY KEY (published, fingerprint)
+		)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	s := &sqliteSink{db: db}
+	if err := s.beginTx(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *sqliteSink) beginTx() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	insertStatus, err := tx.Prepare(`INSERT INTO router_status (valid_after, fingerprint, exit_flag) VALUES (?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	insertDesc, err := tx.Prepare(`INSERT INTO router_descriptor (published, fingerprint, bandwidth_avg, bandwidth_burst, bandwidth_obs) VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	s.tx = tx
+	s.insertStatus = insertStatus
+	s.insertDesc = insertDesc
+	return nil
+}
+
+func (s *sqliteSink) WriteStatus(validAfter time.Time, status *zoossh.RouterStatus) error {
+	_, err := s.insertStatus.Exec(validAfter.Unix(), string(status.Fingerprint), status.Flags.Exit)
+	return err
+}
+
+func (s *sqliteSink) WriteDescriptor(desc *zoossh.RouterDescriptor) error {
+	_, err := s.insertDesc.Exec(desc.Published.Unix(), string(desc.Fingerprint), desc.BandwidthAvg, desc.BandwidthBurst, desc.BandwidthObs)
+	return err
+}
+
+// Flush commits the transaction accumulated since the last Flush (or since
+// open) and starts a fresh one for the next archive file.
+func (s *sqliteSink) Flush() error {
+	commitErr := s.tx.Commit()
+	if commitErr != nil {
+		s.tx.Rollback()
+	}
+	if err := s.beginTx(); err != nil && commitErr == nil {
+		commitErr = err
+	}
+	return commitErr
+}
+
+// Discard rolls back the transaction accumulated since the last Flush and
+// starts a fresh one, so a mid-file write error can't have its partial rows
+// swept into the next file's commit.
+func (s *sqliteSink) Discard() error {
+	rollbackErr := s.tx.Rollback()
+	if err := s.beginTx(); err != nil && rollbackErr == nil {
+		rollbackErr = err
+	}
+	return rollbackErr
+}
+
+func (s *sqliteSink) Close() error {
+	s.tx.Rollback()
+	return s.db.Close()
+}
+
+// withParseMetrics times fn and records it against kind ("consensus" or
+// "descriptor"), so the -metrics-addr endpoint reflects both archive types
+// without duplicating the bookkeeping at every call site.
+func withParseMetrics(kind string, fn func() error) error {
+	start := time.Now()
+	err := fn()
+	parseDurationSeconds.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+	if err != nil {
+		parseErrorsTotal.WithLabelValues(kind, "parse").Inc()
+		return perFileErr{err}
+	}
+	filesParsedTotal.WithLabelValues(kind).Inc()
+	return nil
+}
+
+// perFileErr marks an error as already accounted for in parseErrorsTotal (by
+// withParseMetrics), so walkConsensuses/walkDescriptors can tell a per-file
+// parse failure apart from a walk-level error (a corrupt tarball, a
+// permission-denied directory) that withParseMetrics never saw.
+type perFileErr struct{ err error }
+
+func (e perFileErr) Error() string { return e.err.Error() }
+func (e perFileErr) Unwrap() error { return e.err }
+
+// Stats holds the aggregate results of a single archive walk. Returning
+// these rather than mutating package globals lets the benchmark be reused
+// from tests or a larger CI run without leaking state between invocations.
+type Stats struct {
+	TotalRelays    int64
+	TotalExits     int64
+	TotalBw        uint64
+	ProcessedCount int64
+	Elapsed        time.Duration
+}
+
+func Min(a uint64, b uint64, c uint64) uint64 {
+
+	min := a
+
+	if b < min {
+		min = b
+	}
+
+	if c < min {
+		min = c
+	}
+
+	return min
+}
+
+// walkArchive fans the regular files under root into a bounded pool of
+// worker goroutines and calls fn for each one. It blocks until every file
+// has been visited or the directory walk itself fails; an error from fn does
+// not stop the walk and is returned once every file has been visited.
+// workers <= 0 defaults to runtime.NumCPU().
+func walkArchive(root string, workers int, fn func(path string) error) error {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	paths := make(chan string)
+	errs := make(chan error, workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				if err := fn(path); err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+				}
+			}
+		}()
+	}
+
+	walkErr := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		paths <- path
+		return nil
+	})
+	close(paths)
+	wg.Wait()
+	close(errs)
+
+	if walkErr != nil {
+		return walkErr
+	}
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isTarball reports whether path looks like one of the monthly CollecTor
+// tarballs (.tar, .tar.gz, .tar.xz) rather than an already-extracted
+// directory tree.
+func isTarball(path string) bool {
+	return strings.HasSuffix(path, ".tar") ||
+		strings.HasSuffix(path, ".tar.gz") ||
+		strings.HasSuffix(path, ".tar.xz")
+}
+
+// openTarReader opens path and, based on its extension, wraps it with
+// whatever decompression is needed so the caller can treat every CollecTor
+// tarball as a plain tar stream.
+func openTarReader(path string) (*tar.Reader, io.Closer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch {
+	case strings.HasSuffix(path, ".tar.gz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return tar.NewReader(gz), f, nil
+	case strings.HasSuffix(path, ".tar.xz"):
+		xzr, err := xz.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return tar.NewReader(xzr), f, nil
+	default:
+		return tar.NewReader(f), f, nil
+	}
+}
+
+// walkTarball streams path entry by entry, spooling each regular file to a
+// temp file and calling fn with its path, so callers can parse tarball
+// members with the same *File parsers used for an already-extracted
+// directory without pre-extracting the whole archive up front.
+func walkTarball(path string, fn func(path string) error) error {
+	tr, closer, err := openTarReader(path)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		tmp, err := os.CreateTemp("", "zoossh-benchmark-*")
+		if err != nil {
+			return err
+		}
+		_, copyErr := io.Copy(tmp, tr)
+		closeErr := tmp.Close()
+
+		err = copyErr
+		if err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = fn(tmp.Name())
+		}
+		os.Remove(tmp.Name())
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// walkEntries visits every file under root, whether root is a directory of
+// already-extracted files or a CollecTor tarball, and calls fn with each
+// file's path.
+func walkEntries(root string, fn func(path string) error) error {
+	if isTarball(root) {
+		return walkTarball(root, fn)
+	}
+	return walkArchive(root, runtime.NumCPU(), fn)
+}
+
+func walkConsensuses(root string, reporter ProgressReporter, sink Sink) (Stats, error) {
+
+	var stats Stats
+	var mu sync.Mutex
+
+	reduce := func(consensus *zoossh.Consensus, path string, fileStart time.Time) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, getStatus := range consensus.RouterStatuses {
+			status := getStatus()
+			stats.TotalRelays++
+			routerStatusesTotal.Inc()
+			if status.Flags.Exit == true {
+				stats.TotalExits++
+				exitRelaysTotal.Inc()
+			}
+			if sink != nil {
+				if err := sink.WriteStatus(consensus.ValidAfter, status); err != nil {
+					sink.Discard()
+					return err
+				}
+			}
+		}
+		stats.ProcessedCount++
+		reporter.OnFile(path, KindConsensus, time.Since(fileStart))
+		reporter.OnBatch(stats.ProcessedCount, 0)
+
+		if sink != nil {
+			return sink.Flush()
+		}
+		return nil
+	}
+
+	before := time.Now()
+	err := walkEntries(root, func(path string) error {
+		fileStart := time.Now()
+		return withParseMetrics("consensus", func() error {
+			consensus, err := zoossh.ParseConsensusFile(path)
+			if err != nil {
+				return err
+			}
+			return reduce(consensus, path, fileStart)
+		})
+	})
+	stats.Elapsed = time.Since(before)
+	reporter.OnDone(KindConsensus, stats)
+
+	var pe perFileErr
+	if err != nil && !errors.As(err, &pe) {
+		parseErrorsTotal.WithLabelValues("consensus", "walk").Inc()
+	}
+	return stats, err
+}
+
+func walkDescriptors(root string, reporter ProgressReporter, sink Sink) (Stats, error) {
+
+	var stats Stats
+	var files int64
+	var mu sync.Mutex
+
+	reduce := func(descriptors *zoossh.RouterDescriptors, path string, fileStart time.Time) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		files++
+		for _, getDesc := range descriptors.RouterDescriptors {
+			desc := getDesc()
+			stats.TotalBw += Min(desc.BandwidthAvg, desc.BandwidthBurst, desc.BandwidthObs)
+			stats.ProcessedCount++
+			if sink != nil {
+				if err := sink.WriteDescriptor(desc); err != nil {
+					sink.Discard()
+					return err
+				}
+			}
+		}
+		bandwidthBytesAvg.Set(float64(stats.TotalBw) / float64(stats.ProcessedCount))
+		reporter.OnFile(path, KindDescriptor, time.Since(fileStart))
+		reporter.OnBatch(files, 0)
+
+		if sink != nil {
+			return sink.Flush()
+		}
+		return nil
+	}
+
+	before := time.Now()
+	err := walkEntries(root, func(path string) error {
+		fileStart := time.Now()
+		return withParseMetrics("descriptor", func() error {
+			descriptors, err := zoossh.ParseDescriptorFile(path)
+			if err != nil {
+				return err
+			}
+			return reduce(descriptors, path, fileStart)
+		})
+	})
+	stats.Elapsed = time.Since(before)
+	reporter.OnDone(KindDescriptor, stats)
+
+	var pe perFileErr
+	if err != nil && !errors.As(err, &pe) {
+		parseErrorsTotal.WithLabelValues("descriptor", "walk").Inc()
+	}
+	return stats, err
+}
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+	if len(args) != 2 {
+		log.Fatalf("Usage: %s [-metrics-addr ADDR] [-json-log] [-sqlite path.db] CONSENSUS_ARCHIVE DESCRIPTOR_ARCHIVE", os.Args[0])
+	}
+
+	if *metricsAddr != "" {
+		go func() {
+			http.Handle("/metrics", promhttp.Handler())
+			log.Println(http.ListenAndServe(*metricsAddr, nil))
+		}()
+	}
+
+	var sink Sink
+	if *sqlitePath != "" {
+		s, err := openSQLiteSink(*sqlitePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer s.Close()
+		sink = s
+	}
+
+	var logger Logger
+	if *jsonLog {
+		logger = slogPrintf{l: slog.New(slog.NewJSONHandler(os.Stderr, nil))}
+	} else {
+		logger = log.Default()
+	}
+	reporter := newTerminalReporter(logger)
+
+	// The two archive arguments may each be either a directory of
+	// already-extracted files or a CollecTor tarball
+	// (consensuses-2015-11.tar.xz, server-descriptors-2015-11.tar.xz).
+
+	consensusStats, err := walkConsensuses(args[0], reporter, sink)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Total time for consensuses:", consensusStats.Elapsed)
+	fmt.Printf("Time per consensus: %dms\n",
+		consensusStats.Elapsed.Nanoseconds()/consensusStats.ProcessedCount/int64(1000000))
+	fmt.Printf("Processed %d consensuses with %d router status entries.\n",
+		consensusStats.ProcessedCount, consensusStats.TotalRelays)
+	fmt.Printf("Total exits: %d\n", consensusStats.TotalExits)
+
+	descriptorStats, err := walkDescriptors(args[1], reporter, sink)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Total time for descriptors:", descriptorStats.Elapsed)
+	fmt.Printf("Time per descriptor: %dns\n",
+		descriptorStats.Elapsed.Nanoseconds()/descriptorStats.ProcessedCount)
+	fmt.Printf("Processed %d descriptors.\n", descriptorStats.ProcessedCount)
+	fmt.Printf("Average advertised bandwidth: %d\n", descriptorStats.TotalBw/uint64(descriptorStats.ProcessedCount))
+}